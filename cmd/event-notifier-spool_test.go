@@ -0,0 +1,57 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import "testing"
+
+func TestDrainRange(t *testing.T) {
+	testCases := []struct {
+		drainedThrough    int
+		activeSegment     int
+		activeSegmentSize int64
+		wantStart         int
+		wantEnd           int
+		wantFrozen        int
+	}{
+		// Active segment has unflushed data: it must be frozen (bumped)
+		// before flush() reads anything, and draining covers it too.
+		{drainedThrough: 0, activeSegment: 0, activeSegmentSize: 100, wantStart: 0, wantEnd: 0, wantFrozen: 1},
+		// Active segment is empty (just rolled, or nothing appended
+		// yet): nothing to freeze, draining stops at the prior segment.
+		{drainedThrough: 0, activeSegment: 2, activeSegmentSize: 0, wantStart: 0, wantEnd: 1, wantFrozen: -1},
+		// Everything already drained and the active segment is empty:
+		// the range is empty (end < start) and nothing is frozen.
+		{drainedThrough: 2, activeSegment: 2, activeSegmentSize: 0, wantStart: 2, wantEnd: 1, wantFrozen: -1},
+	}
+
+	for i, tc := range testCases {
+		start, end, frozen := drainRange(tc.drainedThrough, tc.activeSegment, tc.activeSegmentSize)
+		if start != tc.wantStart || end != tc.wantEnd || frozen != tc.wantFrozen {
+			t.Errorf("case %d: drainRange(%d, %d, %d) = (%d, %d, %d), want (%d, %d, %d)",
+				i, tc.drainedThrough, tc.activeSegment, tc.activeSegmentSize,
+				start, end, frozen, tc.wantStart, tc.wantEnd, tc.wantFrozen)
+		}
+	}
+}
+
+func TestNewSpoolSegmentName(t *testing.T) {
+	name := newSpoolSegmentName("arn:minio:sns:us-east-1:1:kafka", 7)
+	want := "event-spool/arn:minio:sns:us-east-1:1:kafka/0000000007.log"
+	if name != want {
+		t.Errorf("newSpoolSegmentName() = %q, want %q", name, want)
+	}
+}
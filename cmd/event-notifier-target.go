@@ -0,0 +1,47 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// notificationTarget is implemented by every durable notification
+// destination (NATS JetStream, Kafka, ...). Unlike the older logrus.Hook
+// based notifiers, a notificationTarget is handed batches of events by
+// an eventSpool flusher and is expected to retry internally or return an
+// error so the spool can retry the whole batch on the next tick.
+type notificationTarget interface {
+	// Send delivers a batch of events, returning an error if any of
+	// them could not be confirmed as delivered. The whole batch is
+	// retried by the caller on the next flush. ctx carries the
+	// dispatch span started by startNotifySpan for this flush attempt;
+	// its trace id is not linked to the originating S3 request.
+	Send(ctx context.Context, events []NotificationEvent) error
+
+	// Close releases any resources (connections, producers) held by
+	// the target.
+	Close() error
+}
+
+// jsonMarshalNotificationEvent is a small helper shared by targets that
+// publish one message per event (as opposed to the batched "Records"
+// envelope used by writeNotification).
+func jsonMarshalNotificationEvent(event NotificationEvent) ([]byte, error) {
+	return json.Marshal(event)
+}
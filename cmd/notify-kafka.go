@@ -0,0 +1,262 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Shopify/sarama"
+)
+
+// queueTypeKafka is the ARN service identifier for the Kafka target,
+// e.g. arn:minio:sns:us-east-1:1:kafka.
+const queueTypeKafka = "kafka"
+
+// kafkaSASLMechanism enumerates the SASL mechanisms accepted by
+// notify.kafka.saslMechanism.
+type kafkaSASLMechanism string
+
+const (
+	kafkaSASLPlain       kafkaSASLMechanism = "PLAIN"
+	kafkaSASLScramSHA256 kafkaSASLMechanism = "SCRAM-SHA-256"
+	kafkaSASLScramSHA512 kafkaSASLMechanism = "SCRAM-SHA-512"
+)
+
+// kafkaNotify carries the notify.kafka section of serverConfig.
+type kafkaNotify struct {
+	Enable  bool     `json:"enable"`
+	Brokers []string `json:"brokers"`
+	Topic   string   `json:"topic"`
+
+	SASLEnable    bool               `json:"saslEnable"`
+	SASLMechanism kafkaSASLMechanism `json:"saslMechanism"`
+	SASLUsername  string             `json:"saslUsername"`
+	SASLPassword  string             `json:"saslPassword"`
+
+	TLSEnable     bool   `json:"tlsEnable"`
+	TLSSkipVerify bool   `json:"tlsSkipVerify"`
+	TLSClientCert string `json:"tlsClientCert"`
+	TLSClientKey  string `json:"tlsClientKey"`
+	TLSCACert     string `json:"tlsCACert"`
+
+	// BatchSize bounds how many Kafka messages kafkaConn.Send produces
+	// in a single batch call to the broker. Send slices whatever batch
+	// it was given into chunks of at most BatchSize, or sends it all as
+	// one batch when BatchSize is zero.
+	BatchSize int `json:"batchSize"`
+
+	// Encoding selects the wire format produced to Topic: the default
+	// Minio/S3 NotificationEvent JSON, or a CloudEvents 1.0 structured
+	// JSON message. Kafka has no notion of HTTP headers, so CloudEvents
+	// binary content mode (ce-* headers) does not apply here - that is
+	// only meaningful for the HTTP webhook target.
+	Encoding notificationEncoding `json:"encoding"`
+}
+
+// Validate returns an error if the Kafka target configuration is
+// incomplete or inconsistent. Called by newKafkaNotify before it dials,
+// so a PUT of a notification configuration naming a "kafka" ARN fails
+// when this does; validateNotificationConfig does not call it.
+func (k *kafkaNotify) Validate() error {
+	if !k.Enable {
+		return nil
+	}
+	if len(k.Brokers) == 0 {
+		return errors.New("kafka: brokers cannot be empty")
+	}
+	if k.Topic == "" {
+		return errors.New("kafka: topic cannot be empty")
+	}
+	if k.SASLEnable {
+		switch k.SASLMechanism {
+		case kafkaSASLPlain, kafkaSASLScramSHA256, kafkaSASLScramSHA512:
+		default:
+			return fmt.Errorf("kafka: unsupported saslMechanism %q", k.SASLMechanism)
+		}
+		if k.SASLUsername == "" || k.SASLPassword == "" {
+			return errors.New("kafka: saslUsername and saslPassword are required when SASL is enabled")
+		}
+	}
+	if k.TLSEnable && !k.TLSSkipVerify && k.TLSCACert == "" {
+		return errors.New("kafka: tlsCACert is required unless tlsSkipVerify is set")
+	}
+	switch k.Encoding {
+	case "", encodingS3, encodingCloudEvents:
+	default:
+		return fmt.Errorf("kafka: unsupported encoding %q", k.Encoding)
+	}
+	return nil
+}
+
+// kafkaConn is a notificationTarget that produces events to a Kafka
+// topic. Send slices each event batch it is given into chunks of at
+// most BatchSize messages per produce call. The sarama producer retries
+// a failed produce up to 10 times (Producer.Retry.Max); a produce that
+// still fails is returned as an error to the caller (an eventSpool
+// flusher), which retries the whole batch on its next tick.
+type kafkaConn struct {
+	config   kafkaNotify
+	producer sarama.SyncProducer
+}
+
+// newKafkaNotify builds the sarama client configuration for SASL/TLS and
+// dials the configured brokers, returning a ready-to-use notificationTarget.
+func newKafkaNotify(accountID string) (notificationTarget, error) {
+	kConfig := serverConfig.Notify.GetKafkaByID(accountID)
+	if err := kConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 10
+	config.Producer.Return.Successes = true
+
+	if kConfig.SASLEnable {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.User = kConfig.SASLUsername
+		config.Net.SASL.Password = kConfig.SASLPassword
+		switch kConfig.SASLMechanism {
+		case kafkaSASLScramSHA256:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		case kafkaSASLScramSHA512:
+			config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		default:
+			config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		}
+	}
+
+	if kConfig.TLSEnable {
+		tlsConfig, err := newKafkaTLSConfig(kConfig)
+		if err != nil {
+			return nil, err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	producer, err := sarama.NewSyncProducer(kConfig.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("kafka: unable to create producer: %v", err)
+	}
+
+	return &kafkaConn{config: kConfig, producer: producer}, nil
+}
+
+// newKafkaTLSConfig builds a tls.Config for mTLS, optionally verifying
+// the broker certificate against a supplied CA bundle.
+func newKafkaTLSConfig(kConfig kafkaNotify) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: kConfig.TLSSkipVerify}
+
+	if kConfig.TLSClientCert != "" && kConfig.TLSClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(kConfig.TLSClientCert, kConfig.TLSClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: unable to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if kConfig.TLSCACert != "" {
+		caCert, err := ioutil.ReadFile(kConfig.TLSCACert)
+		if err != nil {
+			return nil, fmt.Errorf("kafka: unable to read CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, errors.New("kafka: unable to parse CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// Send produces events to the configured topic in chunks of at most
+// k.config.BatchSize messages (or one batch call for the whole slice
+// when BatchSize is zero), keyed by each event's object key so that all
+// events for a given object land on the same partition and preserve
+// ordering. If ctx carries a dispatch span, produce failures are logged
+// against its trace id and its bucket is used as the CloudEvents source
+// when Encoding is set to cloudevents.
+func (k *kafkaConn) Send(ctx context.Context, events []NotificationEvent) error {
+	batchSize := k.config.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(events)
+	}
+	for start := 0; start < len(events); start += batchSize {
+		end := start + batchSize
+		if end > len(events) {
+			end = len(events)
+		}
+		if err := k.sendBatch(ctx, events[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendBatch produces one Kafka message per event in events via a single
+// SendMessages call.
+func (k *kafkaConn) sendBatch(ctx context.Context, events []NotificationEvent) error {
+	msgs := make([]*sarama.ProducerMessage, 0, len(events))
+	for _, event := range events {
+		data, err := k.encode(ctx, event)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, &sarama.ProducerMessage{
+			Topic: k.config.Topic,
+			Key:   sarama.StringEncoder(event.S3.Object.Key),
+			Value: sarama.ByteEncoder(data),
+		})
+	}
+	if err := k.producer.SendMessages(msgs); err != nil {
+		if span, ok := spanFromContext(ctx); ok {
+			return fmt.Errorf("kafka: produce failed (trace=%s): %v", span.TraceID, err)
+		}
+		return fmt.Errorf("kafka: produce failed: %v", err)
+	}
+	return nil
+}
+
+// encode serializes event per k.config.Encoding: the default
+// NotificationEvent JSON, or a CloudEvents 1.0 structured message when
+// Encoding is "cloudevents".
+func (k *kafkaConn) encode(ctx context.Context, event NotificationEvent) ([]byte, error) {
+	if k.config.Encoding != encodingCloudEvents {
+		return jsonMarshalNotificationEvent(event)
+	}
+	var bucket string
+	if span, ok := spanFromContext(ctx); ok {
+		bucket = span.Bucket
+	}
+	ce, err := newCloudEvent(serverConfig.GetRegion(), bucket, event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ce)
+}
+
+// Close shuts down the underlying Kafka producer.
+func (k *kafkaConn) Close() error {
+	return k.producer.Close()
+}
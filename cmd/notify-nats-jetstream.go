@@ -0,0 +1,192 @@
+/*
+ * Minio Cloud Storage, (C) 2016 Minio, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	nats "github.com/nats-io/go-nats"
+	"github.com/nats-io/go-nats-streaming"
+)
+
+// queueTypeNATSJetStream is the ARN service identifier for the durable
+// NATS JetStream target, e.g. arn:minio:sns:us-east-1:1:nats-jetstream.
+const queueTypeNATSJetStream = "nats-jetstream"
+
+// natsJetStreamNotify carries the notify.nats-jetstream section of
+// serverConfig. It is intentionally similar in shape to the other
+// notify.* target configs so that config-migration code can treat all
+// targets uniformly.
+type natsJetStreamNotify struct {
+	Enable   bool   `json:"enable"`
+	Address  string `json:"address"`
+	Cluster  string `json:"cluster"`
+	Stream   string `json:"stream"`
+	Subject  string `json:"subject"`
+	Durable  string `json:"durable"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	TLS      bool   `json:"tls"`
+	CertPath string `json:"certPath"`
+	KeyPath  string `json:"keyPath"`
+
+	// Encoding selects the wire format published to Subject: the
+	// default Minio/S3 NotificationEvent JSON, or a CloudEvents 1.0
+	// structured JSON message.
+	Encoding notificationEncoding `json:"encoding"`
+}
+
+// Validate returns an error if the NATS JetStream target configuration is
+// incomplete. It is called by newNATSJetStreamNotify before it dials, so
+// a PUT of a notification configuration naming a "nats-jetstream" ARN
+// fails when this does; validateNotificationConfig does not call it.
+func (n *natsJetStreamNotify) Validate() error {
+	if !n.Enable {
+		return nil
+	}
+	if n.Address == "" {
+		return errors.New("nats-jetstream: address cannot be empty")
+	}
+	if n.Cluster == "" {
+		return errors.New("nats-jetstream: cluster cannot be empty")
+	}
+	if n.Subject == "" {
+		return errors.New("nats-jetstream: subject cannot be empty")
+	}
+	if n.Durable == "" {
+		return errors.New("nats-jetstream: durable name cannot be empty")
+	}
+	if n.TLS && (n.CertPath == "" || n.KeyPath == "") {
+		return errors.New("nats-jetstream: certPath and keyPath are required when tls is enabled")
+	}
+	switch n.Encoding {
+	case "", encodingS3, encodingCloudEvents:
+	default:
+		return fmt.Errorf("nats-jetstream: unsupported encoding %q", n.Encoding)
+	}
+	return nil
+}
+
+// natsJetStreamConn is a notificationTarget that publishes to a NATS
+// Streaming (JetStream) cluster. Unlike the plain NATS target, messages
+// are published with the synchronous Publish and only considered
+// delivered once the server acknowledges them. It only publishes: it
+// creates no subscription, and the Durable and Stream settings are not
+// used after Validate.
+type natsJetStreamConn struct {
+	accountID string
+	config    natsJetStreamNotify
+	nc        *nats.Conn
+	conn      stan.Conn
+}
+
+// newNATSJetStreamNotify dials the configured NATS Streaming cluster and
+// returns a notificationTarget ready to be handed to an eventSpool
+// flusher. The connection uses a fixed client ID derived from
+// accountID, so every reconnect presents the same client ID. When TLS
+// is enabled, the
+// client certificate is dialed directly with the nats.io client
+// (nats.ClientCert) and that connection is handed to the streaming
+// client via stan.NatsConn, rather than the mTLS configuration being
+// silently dropped.
+func newNATSJetStreamNotify(accountID string) (notificationTarget, error) {
+	nConfig := serverConfig.Notify.GetNATSJetStreamByID(accountID)
+	if err := nConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	var nc *nats.Conn
+	var opts []stan.Option
+	if nConfig.TLS {
+		var err error
+		nc, err = nats.Connect(nConfig.Address, nats.ClientCert(nConfig.CertPath, nConfig.KeyPath))
+		if err != nil {
+			return nil, fmt.Errorf("nats-jetstream: unable to dial with client certificate: %v", err)
+		}
+		opts = append(opts, stan.NatsConn(nc))
+	} else {
+		opts = append(opts, stan.NatsURL(nConfig.Address))
+	}
+
+	sc, err := stan.Connect(nConfig.Cluster, "minio-jetstream-"+accountID, opts...)
+	if err != nil {
+		if nc != nil {
+			nc.Close()
+		}
+		return nil, fmt.Errorf("nats-jetstream: unable to connect: %v", err)
+	}
+
+	return &natsJetStreamConn{
+		accountID: accountID,
+		config:    nConfig,
+		nc:        nc,
+		conn:      sc,
+	}, nil
+}
+
+// Send publishes events to the configured subject and blocks until the
+// NATS Streaming server has acknowledged the publish, satisfying the
+// at-least-once contract the event spool relies on. If ctx carries a
+// dispatch span, publish failures are logged against its trace id and
+// its bucket is used as the CloudEvents source when Encoding is set to
+// cloudevents.
+func (n *natsJetStreamConn) Send(ctx context.Context, events []NotificationEvent) error {
+	for _, event := range events {
+		data, err := n.encode(ctx, event)
+		if err != nil {
+			return err
+		}
+		if err = n.conn.Publish(n.config.Subject, data); err != nil {
+			if span, ok := spanFromContext(ctx); ok {
+				return fmt.Errorf("nats-jetstream: publish failed (trace=%s): %v", span.TraceID, err)
+			}
+			return fmt.Errorf("nats-jetstream: publish failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// encode serializes event per n.config.Encoding: the default
+// NotificationEvent JSON, or a CloudEvents 1.0 structured message when
+// Encoding is "cloudevents".
+func (n *natsJetStreamConn) encode(ctx context.Context, event NotificationEvent) ([]byte, error) {
+	if n.config.Encoding != encodingCloudEvents {
+		return jsonMarshalNotificationEvent(event)
+	}
+	var bucket string
+	if span, ok := spanFromContext(ctx); ok {
+		bucket = span.Bucket
+	}
+	ce, err := newCloudEvent(serverConfig.GetRegion(), bucket, event)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(ce)
+}
+
+// Close closes the streaming connection and, when TLS was enabled, the
+// NATS connection it was dialed over.
+func (n *natsJetStreamConn) Close() error {
+	err := n.conn.Close()
+	if n.nc != nil {
+		n.nc.Close()
+	}
+	return err
+}